@@ -0,0 +1,86 @@
+// Package apierr round-trips the sentinel errors guard's server returns
+// (ErrTunnelExists, "peer not found", ...) through grpc so a remote CLI
+// can tell them apart the same way an in-process caller does. Domain
+// packages register their sentinels with Register, the server converts
+// them with ToGRPC before returning from an RPC, and the client recovers
+// the original sentinel with FromGRPC.
+package apierr
+
+import (
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const domain = "guard"
+
+type entry struct {
+	err    error
+	code   codes.Code
+	reason string
+}
+
+var (
+	byMessage = map[string]entry{}
+	byReason  = map[string]error{}
+)
+
+// Register associates a sentinel error with the grpc code and
+// ErrorInfo reason it should round-trip as. It panics on a duplicate
+// reason since that would make FromGRPC ambiguous.
+func Register(err error, code codes.Code, reason string) {
+	if _, ok := byReason[reason]; ok {
+		panic("apierr: reason already registered: " + reason)
+	}
+	byMessage[err.Error()] = entry{err: err, code: code, reason: reason}
+	byReason[reason] = err
+}
+
+// ToGRPC converts a registered sentinel error (or one wrapped with
+// github.com/pkg/errors) into a status.Status carrying an ErrorInfo
+// detail with the sentinel's reason. Unregistered errors become
+// codes.Internal with no details, and errors that already carry a grpc
+// status are returned unchanged.
+func ToGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	e, ok := byMessage[errors.Cause(err).Error()]
+	if !ok {
+		return status.Error(codes.Internal, err.Error())
+	}
+	st, derr := status.New(e.code, e.err.Error()).WithDetails(&errdetails.ErrorInfo{
+		Reason: e.reason,
+		Domain: domain,
+	})
+	if derr != nil {
+		return status.Error(e.code, e.err.Error())
+	}
+	return st.Err()
+}
+
+// FromGRPC is the inverse of ToGRPC: given an error returned from a grpc
+// call, it looks for an ErrorInfo detail with a registered reason and
+// returns the original sentinel so callers can keep comparing errors
+// with == the way they do against an in-process server.
+func FromGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == domain {
+			if sentinel, ok := byReason[info.Reason]; ok {
+				return sentinel
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package apierr_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/crosbymichael/guard/apierr"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var errWidgetNotFound = errors.New("widget not found")
+
+func init() {
+	apierr.Register(errWidgetNotFound, codes.NotFound, "WIDGET_NOT_FOUND")
+}
+
+// healthServer is a minimal HealthServer whose Check handler always
+// returns a registered sentinel, standing in for a real guard RPC so the
+// test can exercise the full client/server grpc path.
+type healthServer struct{}
+
+func (healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	return nil, errWidgetNotFound
+}
+
+func (healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	return status.Error(codes.Unimplemented, "watch not implemented")
+}
+
+func errUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	return resp, apierr.ToGRPC(err)
+}
+
+func clientUnary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return apierr.FromGRPC(invoker(ctx, method, req, reply, cc, opts...))
+}
+
+func dialBufconn(t *testing.T) (healthpb.HealthClient, func()) {
+	t.Helper()
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(errUnary))
+	healthpb.RegisterHealthServer(srv, healthServer{})
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}),
+		grpc.WithUnaryInterceptor(clientUnary),
+	)
+	if err != nil {
+		t.Fatalf("dial bufconn: %v", err)
+	}
+	return healthpb.NewHealthClient(conn), func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func TestRoundTripPreservesSentinelIdentity(t *testing.T) {
+	client, close := dialBufconn(t)
+	defer close()
+
+	_, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != errWidgetNotFound {
+		t.Fatalf("expected errWidgetNotFound identity to survive the wire, got %v", err)
+	}
+}
+
+func TestUnregisteredErrorBecomesInternal(t *testing.T) {
+	err := apierr.ToGRPC(errors.New("some unregistered failure"))
+	st, ok := status.FromError(err)
+	if !ok {
+		t.Fatal("expected a status error")
+	}
+	if st.Code() != codes.Internal {
+		t.Fatalf("expected codes.Internal, got %v", st.Code())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// authFlags are the server-side counterpart to tlsFlags: a bearer token
+// that every RPC must present once the server is reachable off of the
+// 10.199.199.1 tunnel.
+var authFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "token",
+		Usage:  "bearer token required on every rpc",
+		EnvVar: "GUARD_TOKEN",
+	},
+	cli.StringFlag{
+		Name:  "token-file",
+		Usage: "file containing the bearer token required on every rpc",
+	},
+}
+
+// tokenAuth validates the "authorization" metadata on incoming RPCs. A
+// nil *tokenAuth disables authentication entirely, which keeps the default
+// behavior unchanged for operators that only expose guard on the tunnel.
+type tokenAuth struct {
+	token string
+}
+
+func newTokenAuth(clix *cli.Context) (*tokenAuth, error) {
+	token := clix.String("token")
+	if path := clix.String("token-file"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "read token file")
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return nil, nil
+	}
+	return &tokenAuth{token: token}, nil
+}
+
+func (a *tokenAuth) verify(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+	presented := strings.TrimPrefix(values[0], "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(a.token)) != 1 {
+		return status.Error(codes.Unauthenticated, "invalid bearer token")
+	}
+	return nil
+}
+
+func (a *tokenAuth) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := a.verify(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *tokenAuth) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := a.verify(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
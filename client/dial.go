@@ -0,0 +1,146 @@
+// Package client holds the transport-credential plumbing shared by every
+// process that dials a guard server: the main `guard` CLI and the
+// standalone `guard-peer` daemon.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+
+	"github.com/crosbymichael/guard/apierr"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// unwrapUnary recovers the original sentinel error (ErrTunnelExists,
+// etc.) from the status.Status returned over the wire. unwrapStream does
+// the same for the error returned when a stream is opened.
+func unwrapUnary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return apierr.FromGRPC(invoker(ctx, method, req, reply, cc, opts...))
+}
+
+func unwrapStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	return s, apierr.FromGRPC(err)
+}
+
+// Config holds the flags needed to dial a guard server: optional mutual
+// TLS material and an optional bearer token.
+type Config struct {
+	Address string
+
+	TLSCert string
+	TLSKey  string
+	TLSCA   string
+
+	Token     string
+	TokenFile string
+}
+
+// TLSConfig builds a *tls.Config from c, or returns nil, nil if no TLS
+// material was configured so the caller can fall back to an insecure
+// dial.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	if c.TLSCert == "" && c.TLSKey == "" && c.TLSCA == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{}
+	if c.TLSCert != "" || c.TLSKey != "" {
+		pair, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "load tls keypair")
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	if c.TLSCA != "" {
+		data, err := ioutil.ReadFile(c.TLSCA)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca certificate")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificates found in %s", c.TLSCA)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// token resolves the bearer token from either the literal flag or a
+// file, preferring the literal value.
+func (c Config) token() (string, error) {
+	if c.Token != "" {
+		return strings.TrimSpace(c.Token), nil
+	}
+	if c.TokenFile == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(c.TokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "read token file")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// bearerCredentials attaches a bearer token to every RPC via the
+// "authorization" metadata key. RequireTransportSecurity always returns
+// true so grpc refuses to send it unless the dial is actually using TLS,
+// rather than silently leaking it over plaintext.
+type bearerCredentials struct {
+	token string
+}
+
+func (b bearerCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + b.token}, nil
+}
+
+func (b bearerCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+// DialOptions resolves the dial options for c: TLS transport credentials
+// when configured, otherwise an insecure dial, plus per-RPC bearer
+// credentials when a token is set.
+func (c Config) DialOptions() ([]grpc.DialOption, error) {
+	tlsCfg, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.DialOption{
+		grpc.WithUnaryInterceptor(unwrapUnary),
+		grpc.WithStreamInterceptor(unwrapStream),
+	}
+	if tlsCfg != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsCfg)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+	token, err := c.token()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		if tlsCfg == nil {
+			return nil, errors.New("refusing to send a bearer token over a plaintext connection, set --tls-cert/--tls-key/--tls-ca")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerCredentials{token: token}))
+	}
+	return opts, nil
+}
+
+// Dial opens a connection to c.Address using DialOptions.
+func (c Config) Dial() (*grpc.ClientConn, error) {
+	opts, err := c.DialOptions()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(c.Address, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial server")
+	}
+	return conn, nil
+}
@@ -0,0 +1,153 @@
+// Package cluster turns a set of guard servers into a mesh that
+// replicates tunnel and peer state to each other, so operators are not
+// limited to a single box as their control plane.
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// Applier is implemented by the local wireguard server so the cluster
+// package can apply replicated state without depending on its concrete
+// type.
+type Applier interface {
+	Tunnels(ctx context.Context) ([]*v1.Tunnel, error)
+	Apply(ctx context.Context, event *v1.PeerEvent) error
+}
+
+// Member is a known node in the mesh.
+type Member struct {
+	NodeID    string
+	Endpoint  string
+	PublicKey string
+	LastSeen  time.Time
+}
+
+// Cluster tracks the other nodes in the mesh and reconciles local
+// wireguard state against the PeerEvents they gossip.
+type Cluster struct {
+	NodeID    string
+	Endpoint  string
+	PublicKey string
+
+	applier Applier
+
+	mu       sync.Mutex
+	leaderID string
+	members  map[string]*Member
+}
+
+// New creates a Cluster seeded with only the local node. Until Join is
+// called, the local node is its own leader under "first node wins"
+// semantics; a remote node joining this one does not change that.
+func New(nodeID, endpoint, publicKey string, applier Applier) *Cluster {
+	return &Cluster{
+		NodeID:    nodeID,
+		Endpoint:  endpoint,
+		PublicKey: publicKey,
+		applier:   applier,
+		leaderID:  nodeID,
+		members: map[string]*Member{
+			nodeID: {NodeID: nodeID, Endpoint: endpoint, PublicKey: publicKey, LastSeen: time.Now()},
+		},
+	}
+}
+
+// Join dials addr, calls the remote Cluster.Join RPC to register the
+// local node and fetch the current tunnel state, then opens a Watch
+// stream to keep reconciling as the mesh changes.
+func (c *Cluster) Join(ctx context.Context, addr string, dialOpts ...grpc.DialOption) error {
+	conn, err := grpc.Dial(addr, dialOpts...)
+	if err != nil {
+		return errors.Wrap(err, "dial cluster member")
+	}
+	client := v1.NewClusterClient(conn)
+
+	resp, err := client.Join(ctx, &v1.JoinRequest{
+		NodeID:    c.NodeID,
+		Endpoint:  c.Endpoint,
+		PublicKey: c.PublicKey,
+	})
+	if err != nil {
+		conn.Close()
+		return errors.Wrap(err, "join cluster")
+	}
+	c.mu.Lock()
+	c.leaderID = resp.LeaderID
+	c.mu.Unlock()
+
+	for _, t := range resp.Tunnels {
+		for _, p := range t.Peers {
+			if err := c.applier.Apply(ctx, &v1.PeerEvent{
+				Type:     v1.PeerEvent_UPDATE,
+				TunnelID: t.ID,
+				Peer:     p,
+			}); err != nil {
+				conn.Close()
+				return errors.Wrap(err, "reconcile tunnel on join")
+			}
+		}
+	}
+
+	go c.watch(ctx, conn, client)
+	return nil
+}
+
+// watch consumes the gossip stream for as long as ctx is alive,
+// reconnecting the Watch call if the remote hangs up. Reconnection on
+// dial failure is left to the caller restarting Join.
+func (c *Cluster) watch(ctx context.Context, conn *grpc.ClientConn, client v1.ClusterClient) {
+	defer conn.Close()
+	for ctx.Err() == nil {
+		stream, err := client.Watch(ctx, &v1.WatchRequest{NodeID: c.NodeID})
+		if err != nil {
+			logrus.WithError(err).Warn("cluster watch stream failed, retrying")
+			time.Sleep(time.Second)
+			continue
+		}
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				logrus.WithError(err).Warn("cluster watch stream closed, retrying")
+				break
+			}
+			if err := c.applier.Apply(ctx, event); err != nil {
+				logrus.WithError(err).WithField("tunnel", event.TunnelID).Error("apply gossiped peer event")
+			}
+		}
+	}
+}
+
+// Seen records (or refreshes) a member discovered via Join or gossip.
+func (c *Cluster) Seen(m *Member) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m.LastSeen = time.Now()
+	c.members[m.NodeID] = m
+}
+
+// Leader returns the ID of the node this cluster currently considers the
+// seed/leader under first-node-wins semantics.
+func (c *Cluster) Leader() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.leaderID
+}
+
+// Members returns a snapshot of every known node in the mesh.
+func (c *Cluster) Members() []*Member {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	members := make([]*Member, 0, len(c.members))
+	for _, m := range c.members {
+		members = append(members, m)
+	}
+	return members
+}
@@ -0,0 +1,106 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/gogo/protobuf/types"
+)
+
+// subscriberBuffer bounds how many gossiped events a slow watcher can
+// fall behind by; once its buffer is full, Publish discards further
+// events for it while it stays subscribed.
+const subscriberBuffer = 128
+
+// Server implements v1.ClusterServer, accepting Join requests from new
+// nodes and gossiping local peer writes to every subscriber's Watch
+// stream.
+type Server struct {
+	cluster *Cluster
+
+	mu          sync.Mutex
+	subscribers map[chan *v1.PeerEvent]struct{}
+}
+
+// NewServer wraps a Cluster with the grpc handlers new nodes call to
+// join the mesh and watch it for changes.
+func NewServer(c *Cluster) *Server {
+	return &Server{
+		cluster:     c,
+		subscribers: make(map[chan *v1.PeerEvent]struct{}),
+	}
+}
+
+func (s *Server) Join(ctx context.Context, r *v1.JoinRequest) (*v1.JoinResponse, error) {
+	s.cluster.Seen(&Member{NodeID: r.NodeID, Endpoint: r.Endpoint, PublicKey: r.PublicKey})
+
+	tunnels, err := s.cluster.applier.Tunnels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.JoinResponse{
+		Tunnels:  tunnels,
+		LeaderID: s.cluster.Leader(),
+	}, nil
+}
+
+func (s *Server) Watch(r *v1.WatchRequest, stream v1.Cluster_WatchServer) error {
+	ch := make(chan *v1.PeerEvent, subscriberBuffer)
+	s.subscribe(ch)
+	defer s.unsubscribe(ch)
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-ch:
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) Status(ctx context.Context, _ *types.Empty) (*v1.StatusResponse, error) {
+	members := s.cluster.Members()
+	resp := &v1.StatusResponse{Members: make([]*v1.Member, 0, len(members))}
+	for _, m := range members {
+		resp.Members = append(resp.Members, &v1.Member{
+			NodeID:    m.NodeID,
+			Endpoint:  m.Endpoint,
+			PublicKey: m.PublicKey,
+			LastSeen:  m.LastSeen.Unix(),
+		})
+	}
+	return resp, nil
+}
+
+// Publish fans a locally originated peer write out to every connected
+// Watch subscriber, which is how a create/delete on one node reaches the
+// wireguard config on every other node in the mesh.
+func (s *Server) Publish(event *v1.PeerEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// slow subscriber, drop the event rather than block the
+			// node that originated the write.
+		}
+	}
+}
+
+func (s *Server) subscribe(ch chan *v1.PeerEvent) {
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *Server) unsubscribe(ch chan *v1.PeerEvent) {
+	s.mu.Lock()
+	delete(s.subscribers, ch)
+	s.mu.Unlock()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/crosbymichael/guard/cluster"
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+)
+
+// clusterApplier adapts the existing v1.WireguardServer RPC handlers to
+// the cluster.Applier interface, so the cluster package can fetch and
+// reconcile tunnel/peer state without depending on the server's
+// concrete type.
+type clusterApplier struct {
+	wg v1.WireguardServer
+}
+
+func (a *clusterApplier) Tunnels(ctx context.Context) ([]*v1.Tunnel, error) {
+	resp, err := a.wg.List(ctx, &types.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Tunnels, nil
+}
+
+func (a *clusterApplier) Apply(ctx context.Context, event *v1.PeerEvent) error {
+	switch event.Type {
+	case v1.PeerEvent_REMOVE:
+		_, err := a.wg.DeletePeer(ctx, &v1.DeletePeerRequest{
+			ID:     event.TunnelID,
+			PeerID: event.Peer.ID,
+		})
+		return err
+	default:
+		if event.Peer.PublicKey == "" {
+			return errors.New("peer event missing public key")
+		}
+		// PutPeer, not NewPeer: a gossiped peer already has its real
+		// keypair, so reconciling it must install that PublicKey
+		// verbatim rather than minting a new one.
+		_, err := a.wg.PutPeer(ctx, &v1.PutPeerRequest{
+			ID:   event.TunnelID,
+			Peer: event.Peer,
+		})
+		return err
+	}
+}
+
+// relayLocalPeerEvents forwards every peer write observed on the local
+// store to clusterServer, which is how a create/delete on this node
+// actually reaches the other members' Watch streams instead of
+// Server.Publish sitting uncalled.
+func relayLocalPeerEvents(events <-chan *v1.PeerEvent, clusterServer *cluster.Server) {
+	for event := range events {
+		clusterServer.Publish(event)
+	}
+}
+
+// currentGuardTunnel looks up the server's own guard0 tunnel so its
+// public key can seed this node's cluster identity.
+func currentGuardTunnel(ctx context.Context, wg v1.WireguardServer) (*v1.Tunnel, error) {
+	resp, err := wg.List(ctx, &types.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range resp.Tunnels {
+		if t.ID == guardTunnel {
+			return t, nil
+		}
+	}
+	return nil, errors.New("guard tunnel not found")
+}
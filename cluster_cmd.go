@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/gogo/protobuf/types"
+	"github.com/urfave/cli"
+)
+
+var clusterCommand = cli.Command{
+	Name:        "cluster",
+	Description: "manage the guard cluster mesh",
+	Subcommands: []cli.Command{
+		{
+			Name:        "status",
+			Description: "list known cluster members and their last-seen timestamps",
+			Action: func(clix *cli.Context) error {
+				conn, err := dial(clix)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+
+				var (
+					ctx    = cancelContext()
+					client = v1.NewClusterClient(conn)
+				)
+				r, err := client.Status(ctx, &types.Empty{})
+				if err != nil {
+					return err
+				}
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", " ")
+				return enc.Encode(r.Members)
+			},
+		},
+	},
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"sync"
+	"syscall"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// wgInterface is a real, local wireguard network interface materialized
+// from a *v1.Tunnel, replacing the old "copy the config to the box and
+// hope" workflow with one the peer daemon manages live.
+type wgInterface struct {
+	name string
+	link netlink.Link
+	ctrl *wgctrl.Client
+
+	mu           sync.Mutex
+	routesByPeer map[string][]string // last-applied AllowedIPs per peer ID, so a narrowed set can be diffed and withdrawn
+}
+
+func newInterface(name string) (*wgInterface, error) {
+	ctrl, err := wgctrl.New()
+	if err != nil {
+		return nil, errors.Wrap(err, "open wgctrl")
+	}
+	link := &netlink.GenericLink{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+		LinkType:  "wireguard",
+	}
+	if err := netlink.LinkAdd(link); err != nil && !isExists(err) {
+		ctrl.Close()
+		return nil, errors.Wrap(err, "add wireguard link")
+	}
+	return &wgInterface{name: name, link: link, ctrl: ctrl, routesByPeer: make(map[string][]string)}, nil
+}
+
+// Apply brings the interface up with the tunnel's address, configures
+// the wireguard device, installs routes for every peer's AllowedIPs, and
+// writes resolv.conf when the tunnel advertises a DNS server.
+func (w *wgInterface) Apply(t *v1.Tunnel) error {
+	key, err := wgtypes.ParseKey(t.PrivateKey)
+	if err != nil {
+		return errors.Wrap(err, "parse private key")
+	}
+	peerCfgs := make([]wgtypes.PeerConfig, 0, len(t.Peers))
+	for _, p := range t.Peers {
+		cfg, err := peerConfig(p)
+		if err != nil {
+			return err
+		}
+		peerCfgs = append(peerCfgs, cfg)
+	}
+	if err := w.ctrl.ConfigureDevice(w.name, wgtypes.Config{
+		PrivateKey:   &key,
+		ReplacePeers: true,
+		Peers:        peerCfgs,
+	}); err != nil {
+		return errors.Wrap(err, "configure wireguard device")
+	}
+
+	addr, err := netlink.ParseAddr(t.Address)
+	if err != nil {
+		return errors.Wrap(err, "parse tunnel address")
+	}
+	if err := netlink.AddrReplace(w.link, addr); err != nil {
+		return errors.Wrap(err, "set interface address")
+	}
+	if err := netlink.LinkSetUp(w.link); err != nil {
+		return errors.Wrap(err, "bring interface up")
+	}
+	for _, p := range t.Peers {
+		if err := w.applyRoutes(p.ID, p.AllowedIPs); err != nil {
+			return err
+		}
+	}
+	if t.DNS != "" {
+		if err := writeResolvConf(t.DNS); err != nil {
+			return errors.Wrap(err, "write resolv.conf")
+		}
+	}
+	return nil
+}
+
+// ApplyPeer updates a single peer in place, used by the WatchPeer long
+// poll to push rotated keys, new allowed ips, and revocation live. A
+// revoked peer is removed from the device instead of reconfigured.
+func (w *wgInterface) ApplyPeer(p *v1.Peer) error {
+	if p.Revoked {
+		return w.removePeer(p)
+	}
+	cfg, err := peerConfig(p)
+	if err != nil {
+		return err
+	}
+	if err := w.ctrl.ConfigureDevice(w.name, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{cfg},
+	}); err != nil {
+		return errors.Wrap(err, "configure wireguard peer")
+	}
+	return w.applyRoutes(p.ID, p.AllowedIPs)
+}
+
+// removePeer tears down a revoked peer: it is dropped from the wireguard
+// device and every route installed for its AllowedIPs is withdrawn.
+func (w *wgInterface) removePeer(p *v1.Peer) error {
+	key, err := wgtypes.ParseKey(p.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "parse peer public key")
+	}
+	if err := w.ctrl.ConfigureDevice(w.name, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{PublicKey: key, Remove: true}},
+	}); err != nil {
+		return errors.Wrap(err, "remove wireguard peer")
+	}
+	return w.applyRoutes(p.ID, nil)
+}
+
+// applyRoutes installs routes for allowedIPs and withdraws any route
+// previously installed for peerID that is no longer present, so a
+// rotated or narrowed AllowedIPs set doesn't leave a stale route behind.
+func (w *wgInterface) applyRoutes(peerID string, allowedIPs []string) error {
+	w.mu.Lock()
+	previous := w.routesByPeer[peerID]
+	w.mu.Unlock()
+
+	next := make(map[string]struct{}, len(allowedIPs))
+	for _, ip := range allowedIPs {
+		next[ip] = struct{}{}
+	}
+	for _, ip := range previous {
+		if _, ok := next[ip]; ok {
+			continue
+		}
+		if err := w.delRoute(ip); err != nil {
+			return err
+		}
+	}
+	if err := w.addRoutes(allowedIPs); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.routesByPeer[peerID] = allowedIPs
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *wgInterface) addRoutes(allowedIPs []string) error {
+	for _, ip := range allowedIPs {
+		_, dst, err := net.ParseCIDR(ip)
+		if err != nil {
+			return errors.Wrapf(err, "parse allowed ip %s", ip)
+		}
+		if err := netlink.RouteReplace(&netlink.Route{
+			LinkIndex: w.link.Attrs().Index,
+			Dst:       dst,
+		}); err != nil {
+			return errors.Wrapf(err, "install route for %s", ip)
+		}
+	}
+	return nil
+}
+
+func (w *wgInterface) delRoute(ip string) error {
+	_, dst, err := net.ParseCIDR(ip)
+	if err != nil {
+		return errors.Wrapf(err, "parse allowed ip %s", ip)
+	}
+	if err := netlink.RouteDel(&netlink.Route{
+		LinkIndex: w.link.Attrs().Index,
+		Dst:       dst,
+	}); err != nil {
+		return errors.Wrapf(err, "remove stale route for %s", ip)
+	}
+	return nil
+}
+
+func (w *wgInterface) Close() error {
+	w.ctrl.Close()
+	return netlink.LinkDel(w.link)
+}
+
+func peerConfig(p *v1.Peer) (wgtypes.PeerConfig, error) {
+	key, err := wgtypes.ParseKey(p.PublicKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, errors.Wrap(err, "parse peer public key")
+	}
+	allowedIPs := make([]net.IPNet, 0, len(p.AllowedIPs))
+	for _, ip := range p.AllowedIPs {
+		_, n, err := net.ParseCIDR(ip)
+		if err != nil {
+			return wgtypes.PeerConfig{}, errors.Wrapf(err, "parse allowed ip %s", ip)
+		}
+		allowedIPs = append(allowedIPs, *n)
+	}
+	cfg := wgtypes.PeerConfig{
+		PublicKey:         key,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        allowedIPs,
+	}
+	if p.Endpoint != "" {
+		endpoint, err := net.ResolveUDPAddr("udp", p.Endpoint)
+		if err != nil {
+			return wgtypes.PeerConfig{}, errors.Wrap(err, "resolve peer endpoint")
+		}
+		cfg.Endpoint = endpoint
+	}
+	return cfg, nil
+}
+
+func writeResolvConf(dns string) error {
+	return ioutil.WriteFile("/etc/resolv.conf", []byte("nameserver "+dns+"\n"), 0644)
+}
+
+func isExists(err error) bool {
+	return errors.Cause(err) == syscall.EEXIST
+}
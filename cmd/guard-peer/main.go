@@ -0,0 +1,207 @@
+/*
+	Copyright (c) 2019 @crosbymichael
+
+	Permission is hereby granted, free of charge, to any person
+	obtaining a copy of this software and associated documentation
+	files (the "Software"), to deal in the Software without
+	restriction, including without limitation the rights to use, copy,
+	modify, merge, publish, distribute, sublicense, and/or sell copies
+	of the Software, and to permit persons to whom the Software is
+	furnished to do so, subject to the following conditions:
+
+	The above copyright notice and this permission notice shall be
+	included in all copies or substantial portions of the Software.
+
+	THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+	EXPRESS OR IMPLIED,
+	INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+	FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+	IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT
+	HOLDERS BE LIABLE FOR ANY CLAIM,
+	DAMAGES OR OTHER LIABILITY,
+	WHETHER IN AN ACTION OF CONTRACT,
+	TORT OR OTHERWISE,
+	ARISING FROM, OUT OF OR IN CONNECTION WITH
+	THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/crosbymichael/guard/client"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "guard-peer"
+	app.Version = "1"
+	app.Usage = "run this machine as a wireguard peer of a guard server"
+	app.Flags = []cli.Flag{
+		cli.BoolFlag{
+			Name:  "debug",
+			Usage: "enable debug output in the logs",
+		},
+		cli.StringFlag{
+			Name:  "address,a",
+			Usage: "guard server grpc address",
+			Value: "10.199.199.1:10100",
+		},
+		cli.StringFlag{
+			Name:  "tunnel,t",
+			Usage: "tunnel to join on the guard server",
+		},
+		cli.StringFlag{
+			Name:  "peer-id,p",
+			Usage: "id for this peer, defaults to the hostname",
+		},
+		cli.StringFlag{
+			Name:  "interface,i",
+			Usage: "local wireguard interface to create",
+			Value: "guard0",
+		},
+		cli.StringSliceFlag{
+			Name:  "ips",
+			Usage: "allowed ips routed through the tunnel",
+			Value: &cli.StringSlice{},
+		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "bearer token sent on every rpc",
+			EnvVar: "GUARD_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "token-file",
+			Usage: "file containing the bearer token sent on every rpc",
+		},
+		cli.StringFlag{
+			Name:   "tls-cert",
+			Usage:  "tls certificate for mutual tls",
+			EnvVar: "GUARD_TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   "tls-key",
+			Usage:  "tls key for mutual tls",
+			EnvVar: "GUARD_TLS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "tls-ca",
+			Usage:  "ca certificate used to verify the server",
+			EnvVar: "GUARD_TLS_CA",
+		},
+	}
+	app.Before = func(clix *cli.Context) error {
+		if clix.Bool("debug") {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return nil
+	}
+	app.Action = func(clix *cli.Context) error {
+		if os.Geteuid() != 0 {
+			return errors.New("guard-peer must run as root to configure the local interface")
+		}
+		peerID := clix.String("peer-id")
+		if peerID == "" {
+			var err error
+			if peerID, err = os.Hostname(); err != nil {
+				return errors.Wrap(err, "resolve peer id")
+			}
+		}
+		cfg := client.Config{
+			Address:   clix.String("address"),
+			TLSCert:   clix.String("tls-cert"),
+			TLSKey:    clix.String("tls-key"),
+			TLSCA:     clix.String("tls-ca"),
+			Token:     clix.String("token"),
+			TokenFile: clix.String("token-file"),
+		}
+		conn, err := cfg.Dial()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		var (
+			ctx    = context.Background()
+			wg     = v1.NewWireguardClient(conn)
+			ifName = clix.String("interface")
+			tunnel = clix.String("tunnel")
+		)
+		r, err := wg.NewPeer(ctx, &v1.NewPeerRequest{
+			ID:     tunnel,
+			PeerID: peerID,
+		})
+		if err != nil {
+			return errors.Wrap(err, "register peer with guard server")
+		}
+		iface, err := newInterface(ifName)
+		if err != nil {
+			return errors.Wrap(err, "create local interface")
+		}
+		defer iface.Close()
+
+		local := &v1.Tunnel{
+			PrivateKey: r.Peer.PrivateKey,
+			Address:    r.Peer.AllowedIPs[0],
+			DNS:        r.Tunnel.DNS,
+			Peers: []*v1.Peer{
+				{
+					ID:         r.Tunnel.ID,
+					PublicKey:  r.Tunnel.PublicKey,
+					Endpoint:   net.JoinHostPort(r.Tunnel.Endpoint, r.Tunnel.ListenPort),
+					AllowedIPs: clix.StringSlice("ips"),
+				},
+			},
+		}
+		if err := iface.Apply(local); err != nil {
+			return errors.Wrap(err, "apply tunnel config")
+		}
+		logrus.WithField("interface", ifName).Info("peer interface up")
+
+		return watch(ctx, wg, iface, tunnel, peerID)
+	}
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// watch long-polls WatchPeer so that rotated keys, new allowed ips, and
+// revocation are applied to the local interface without a restart. A
+// stream error just means reconnect; a peer marked Revoked means this
+// peer is gone for good, so the interface is torn down instead.
+func watch(ctx context.Context, wg v1.WireguardClient, iface *wgInterface, tunnel, peerID string) error {
+	for ctx.Err() == nil {
+		stream, err := wg.WatchPeer(ctx, &v1.WatchPeerRequest{ID: tunnel, PeerID: peerID})
+		if err != nil {
+			logrus.WithError(err).Warn("watch peer call failed, retrying")
+			time.Sleep(time.Second)
+			continue
+		}
+		for {
+			peer, err := stream.Recv()
+			if err != nil {
+				logrus.WithError(err).Warn("watch peer stream closed, reconnecting")
+				break
+			}
+			if err := iface.ApplyPeer(peer); err != nil {
+				logrus.WithError(err).Error("apply updated peer config")
+				continue
+			}
+			if peer.Revoked {
+				logrus.Info("peer revoked by guard server, tearing down interface")
+				return iface.Close()
+			}
+		}
+	}
+	return ctx.Err()
+}
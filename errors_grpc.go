@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+
+	"github.com/crosbymichael/guard/apierr"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrPeerNotFound and ErrInvalidCIDR are sentinels for the peer/address
+// validation failures that used to come back as bare errors.New strings,
+// so a remote CLI can now compare against them the same way an
+// in-process caller compares against ErrTunnelExists.
+var (
+	ErrPeerNotFound = errors.New("peer not found")
+	ErrInvalidCIDR  = errors.New("invalid CIDR")
+)
+
+func init() {
+	apierr.Register(ErrTunnelExists, codes.AlreadyExists, "TUNNEL_EXISTS")
+	apierr.Register(ErrPeerNotFound, codes.NotFound, "PEER_NOT_FOUND")
+	apierr.Register(ErrInvalidCIDR, codes.InvalidArgument, "INVALID_CIDR")
+}
+
+// errUnary/errStream convert the domain errors returned by the wg
+// handlers into status.Status errors with ErrorInfo details, closest to
+// the handler so grpc_prometheus and sentry see the final grpc code.
+func errUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	return resp, apierr.ToGRPC(err)
+}
+
+func errStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return apierr.ToGRPC(handler(srv, ss))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sync"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// eventSubscriberBuffer bounds how many events a single Events()
+// subscriber can fall behind by before it is dropped.
+const eventSubscriberBuffer = 64
+
+// eventSub is one subscriber's channel plus a one-shot signal that fires
+// when Publish could not keep up and the subscriber must be kicked.
+type eventSub struct {
+	ch      chan *v1.Event
+	dropped chan struct{}
+	once    sync.Once
+}
+
+func (s *eventSub) markDropped() {
+	s.once.Do(func() { close(s.dropped) })
+}
+
+// eventHub fans tunnel/peer lifecycle events out to every Events()
+// subscriber. It is injected into newServer so the Events RPC handler
+// and pollDevice both publish into and serve from the same instance,
+// instead of each ending up with its own disconnected hub.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[*eventSub]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[*eventSub]struct{})}
+}
+
+// Publish fans e out to every subscriber; each subscriber's filter is
+// applied later, in Events. A subscriber
+// that can't keep up is marked dropped rather than blocking the
+// publisher; Events() turns that into a codes.ResourceExhausted error
+// for that one stream.
+func (h *eventHub) Publish(e *v1.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		select {
+		case sub.ch <- e:
+		default:
+			sub.markDropped()
+		}
+	}
+}
+
+func (h *eventHub) subscribe() *eventSub {
+	sub := &eventSub{
+		ch:      make(chan *v1.Event, eventSubscriberBuffer),
+		dropped: make(chan struct{}),
+	}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *eventSub) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// Events implements the Events RPC: it streams every event published to
+// the hub that matches filter until the client disconnects, the server shuts the stream
+// down, or the subscriber falls far enough behind to be dropped.
+func (h *eventHub) Events(filter *v1.EventFilter, stream v1.Wireguard_EventsServer) error {
+	sub := h.subscribe()
+	defer h.unsubscribe(sub)
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-sub.dropped:
+			return status.Error(codes.ResourceExhausted, "event subscriber fell behind")
+		case e := <-sub.ch:
+			if !matchesFilter(filter, e) {
+				continue
+			}
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func matchesFilter(filter *v1.EventFilter, e *v1.Event) bool {
+	if filter == nil || len(filter.TunnelIDs) == 0 {
+		return true
+	}
+	for _, id := range filter.TunnelIDs {
+		if id == e.TunnelID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/urfave/cli"
+)
+
+var eventsCommand = cli.Command{
+	Name:        "events",
+	Description: "tail tunnel/peer lifecycle events",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "tunnel,t",
+			Usage: "only show events for this tunnel, may be repeated",
+			Value: &cli.StringSlice{},
+		},
+	},
+	Action: func(clix *cli.Context) error {
+		conn, err := dial(clix)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		var (
+			ctx    = cancelContext()
+			client = v1.NewWireguardClient(conn)
+		)
+		stream, err := client.Events(ctx, &v1.EventFilter{
+			TunnelIDs: clix.StringSlice("tunnel"),
+		})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		for {
+			event, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(event); err != nil {
+				return err
+			}
+		}
+	},
+}
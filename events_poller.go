@@ -0,0 +1,80 @@
+package main
+
+import (
+	"time"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// peerStat is the last handshake/transfer counters seen for a peer, used
+// to detect new handshakes and bytes-transferred thresholds crossing
+// between polls.
+type peerStat struct {
+	lastHandshake time.Time
+	transmit      int64
+	receive       int64
+}
+
+// pollDevice polls device's wireguard peers on interval and publishes
+// PEER_HANDSHAKE and PEER_BYTES_THRESHOLD events to hub, so operators
+// get a tail -f view of tunnel activity without scraping Prometheus.
+// It runs until stop is closed.
+func pollDevice(ctrl *wgctrl.Client, tunnelID, device string, interval time.Duration, bytesThreshold int64, hub *eventHub, stop <-chan struct{}) {
+	seen := map[string]peerStat{}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			dev, err := ctrl.Device(device)
+			if err != nil {
+				logrus.WithError(err).WithField("device", device).Warn("poll wireguard device")
+				continue
+			}
+			for _, p := range dev.Peers {
+				checkPeer(tunnelID, p, seen, bytesThreshold, hub)
+			}
+		}
+	}
+}
+
+func checkPeer(tunnelID string, p wgtypes.Peer, seen map[string]peerStat, bytesThreshold int64, hub *eventHub) {
+	id := p.PublicKey.String()
+	prev := seen[id]
+	next := peerStat{
+		lastHandshake: p.LastHandshakeTime,
+		transmit:      p.TransmitBytes,
+		receive:       p.ReceiveBytes,
+	}
+	seen[id] = next
+
+	if !next.lastHandshake.IsZero() && next.lastHandshake.After(prev.lastHandshake) {
+		hub.Publish(&v1.Event{
+			Type:      v1.Event_PEER_HANDSHAKE,
+			TunnelID:  tunnelID,
+			PeerID:    id,
+			Timestamp: next.lastHandshake.Unix(),
+		})
+	}
+	if bytesThreshold > 0 {
+		if crossed(prev.transmit, next.transmit, bytesThreshold) || crossed(prev.receive, next.receive, bytesThreshold) {
+			hub.Publish(&v1.Event{
+				Type:          v1.Event_PEER_BYTES_THRESHOLD,
+				TunnelID:      tunnelID,
+				PeerID:        id,
+				Timestamp:     time.Now().Unix(),
+				TransmitBytes: next.transmit,
+				ReceiveBytes:  next.receive,
+			})
+		}
+	}
+}
+
+func crossed(prev, next, threshold int64) bool {
+	return prev/threshold != next/threshold
+}
@@ -29,22 +29,29 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/crosbymichael/guard/cluster"
+	"github.com/crosbymichael/guard/store"
 	"github.com/getsentry/sentry-go"
 	"github.com/gogo/protobuf/types"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"golang.zx2c4.com/wireguard/wgctrl"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
@@ -67,7 +74,17 @@ func main() {
 			Usage:  "sentry DSN",
 			EnvVar: "SENTRY_DSN",
 		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "bearer token sent on every rpc",
+			EnvVar: "GUARD_TOKEN",
+		},
+		cli.StringFlag{
+			Name:  "token-file",
+			Usage: "file containing the bearer token sent on every rpc",
+		},
 	}
+	app.Flags = append(app.Flags, tlsFlags...)
 	app.Before = func(clix *cli.Context) error {
 		if clix.GlobalBool("debug") {
 			logrus.SetLevel(logrus.DebugLevel)
@@ -89,6 +106,8 @@ func main() {
 		listCommand,
 		serverCommand,
 		peersCommand,
+		clusterCommand,
+		eventsCommand,
 	}
 	if err := app.Run(os.Args); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -105,26 +124,77 @@ func main() {
 var serverCommand = cli.Command{
 	Name:        "server",
 	Description: "run the wireguard grpc server",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		cli.StringFlag{
 			Name:  "dir",
-			Usage: "wireguard configuration directory",
+			Usage: "wireguard configuration directory, used when --store is not set",
 			Value: defaultWireguardDir,
 		},
+		cli.StringFlag{
+			Name:  "store",
+			Usage: "where to persist tunnel/peer state: file://, bolt://, or etcd://",
+		},
 		cli.StringFlag{
 			Name:  "endpoint",
 			Usage: "external endpoint address to manage the wireguard",
 		},
-	},
+		cli.StringFlag{
+			Name:  "join",
+			Usage: "address of an existing cluster member to join",
+		},
+		cli.StringFlag{
+			Name:  "node-id",
+			Usage: "unique id for this node in the cluster, defaults to the hostname",
+		},
+		cli.DurationFlag{
+			Name:  "events-interval",
+			Usage: "how often to poll wireguard for handshake/transfer events",
+			Value: 30 * time.Second,
+		},
+		cli.Int64Flag{
+			Name:  "events-bytes-threshold",
+			Usage: "emit a PEER_BYTES_THRESHOLD event every N bytes transferred, 0 disables",
+		},
+	}, append(tlsFlags, authFlags...)...),
 	Action: func(clix *cli.Context) error {
 		if os.Geteuid() != 0 {
 			return errors.New("grpc server must run as root")
 		}
-		wg, err := newServer(clix.String("dir"))
+		rawStore := clix.String("store")
+		if rawStore == "" {
+			// Build the URL from an absolute path rather than
+			// concatenating the literal --dir value: url.Parse treats
+			// "file://relative/dir" as host "relative", path "/dir",
+			// which silently breaks a relative --dir.
+			dir, err := filepath.Abs(clix.String("dir"))
+			if err != nil {
+				return errors.Wrap(err, "resolve store directory")
+			}
+			rawStore = (&url.URL{Scheme: "file", Path: dir}).String()
+		}
+		st, err := store.New(rawStore)
+		if err != nil {
+			return errors.Wrap(err, "open store")
+		}
+		defer st.Close()
+
+		hub := newEventHub()
+		wg, err := newServer(st, hub)
 		if err != nil {
 			return err
 		}
-		server := newGRPC()
+		tlsCfg, err := serverTLSConfig(clix)
+		if err != nil {
+			return err
+		}
+		auth, err := newTokenAuth(clix)
+		if err != nil {
+			return err
+		}
+		if auth != nil && tlsCfg == nil {
+			return errors.New("refusing to require a bearer token without TLS, set --tls-cert/--tls-key or drop --token")
+		}
+		server := newGRPC(tlsCfg, auth)
 
 		v1.RegisterWireguardServer(server, wg)
 
@@ -156,10 +226,48 @@ var serverCommand = cli.Command{
 
 		// create our server tunnel
 
+		nodeID := clix.String("node-id")
+		if nodeID == "" {
+			if nodeID, err = os.Hostname(); err != nil {
+				return errors.Wrap(err, "resolve node id")
+			}
+		}
+		guard, err := currentGuardTunnel(ctx, wg)
+		if err != nil {
+			return err
+		}
+		mesh := cluster.New(nodeID, endpoint, guard.PublicKey, &clusterApplier{wg: wg})
+		clusterServer := cluster.NewServer(mesh)
+		v1.RegisterClusterServer(server, clusterServer)
+		if join := clix.String("join"); join != "" {
+			dialOpts, err := dialOptions(clix)
+			if err != nil {
+				return err
+			}
+			if err := mesh.Join(ctx, join, dialOpts...); err != nil {
+				return errors.Wrap(err, "join cluster")
+			}
+			logrus.WithField("leader", mesh.Leader()).Info("joined cluster")
+		}
+
+		watchCh, err := st.Watch(ctx)
+		if err != nil {
+			return errors.Wrap(err, "watch store")
+		}
+		go relayLocalPeerEvents(watchCh, clusterServer)
+
+		stopPolling := make(chan struct{})
+		if wgCtrl, err := wgctrl.New(); err != nil {
+			logrus.WithError(err).Warn("open wgctrl for event polling")
+		} else {
+			go pollDevice(wgCtrl, guardTunnel, guardTunnel, clix.Duration("events-interval"), clix.Int64("events-bytes-threshold"), hub, stopPolling)
+		}
+
 		signals := make(chan os.Signal, 32)
 		signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
 		go func() {
 			<-signals
+			close(stopPolling)
 			server.Stop()
 		}()
 		l, err := net.Listen("tcp", address)
@@ -186,9 +294,9 @@ var createCommand = cli.Command{
 		},
 	},
 	Action: func(clix *cli.Context) error {
-		conn, err := grpc.Dial(clix.GlobalString("address"), grpc.WithInsecure())
+		conn, err := dial(clix)
 		if err != nil {
-			return errors.Wrap(err, "dial server")
+			return err
 		}
 		defer conn.Close()
 
@@ -213,9 +321,9 @@ var listCommand = cli.Command{
 	Name:        "list",
 	Description: "list all tunnels",
 	Action: func(clix *cli.Context) error {
-		conn, err := grpc.Dial(clix.GlobalString("address"), grpc.WithInsecure())
+		conn, err := dial(clix)
 		if err != nil {
-			return errors.Wrap(err, "dial server")
+			return err
 		}
 		defer conn.Close()
 
@@ -265,9 +373,9 @@ var peersCommand = cli.Command{
 				},
 			},
 			Action: func(clix *cli.Context) error {
-				conn, err := grpc.Dial(clix.GlobalString("address"), grpc.WithInsecure())
+				conn, err := dial(clix)
 				if err != nil {
-					return errors.Wrap(err, "dial server")
+					return err
 				}
 				defer conn.Close()
 
@@ -305,9 +413,9 @@ var peersCommand = cli.Command{
 			Name:        "delete",
 			Description: "delete a peer",
 			Action: func(clix *cli.Context) error {
-				conn, err := grpc.Dial(clix.GlobalString("address"), grpc.WithInsecure())
+				conn, err := dial(clix)
 				if err != nil {
-					return errors.Wrap(err, "dial server")
+					return err
 				}
 				defer conn.Close()
 
@@ -333,9 +441,9 @@ var deleteCommand = cli.Command{
 	Name:        "delete",
 	Description: "delete a tunnel",
 	Action: func(clix *cli.Context) error {
-		conn, err := grpc.Dial(clix.GlobalString("address"), grpc.WithInsecure())
+		conn, err := dial(clix)
 		if err != nil {
-			return errors.Wrap(err, "dial server")
+			return err
 		}
 		defer conn.Close()
 
@@ -352,12 +460,44 @@ var deleteCommand = cli.Command{
 	},
 }
 
-func newGRPC() *grpc.Server {
-	s := grpc.NewServer(
-		grpc.UnaryInterceptor(unary),
-		grpc.StreamInterceptor(stream),
-	)
-	return s
+func newGRPC(tlsCfg *tls.Config, auth *tokenAuth) *grpc.Server {
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(chainUnary(auth.unary, unary, errUnary)),
+		grpc.StreamInterceptor(chainStream(auth.stream, stream, errStream)),
+	}
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+	return grpc.NewServer(opts...)
+}
+
+// chainUnary composes unary server interceptors so the auth check always
+// runs before the prometheus/sentry interceptor that follows it.
+func chainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+// chainStream is the streaming counterpart of chainUnary.
+func chainStream(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return chained(srv, ss)
+	}
 }
 
 func unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
@@ -0,0 +1,185 @@
+// Package bolt is a single-file embedded database store backend,
+// selected with `--store bolt:///var/lib/guard/guard.db`.
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/crosbymichael/guard/store"
+	"github.com/etcd-io/bbolt"
+	"github.com/pkg/errors"
+)
+
+var tunnelsBucket = []byte("tunnels")
+
+type boltStore struct {
+	db *bbolt.DB
+
+	mu       sync.Mutex
+	watchers map[chan *v1.PeerEvent]struct{}
+}
+
+// New opens (creating if necessary) a bbolt database at path.
+func New(path string) (store.Store, error) {
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "open bolt database")
+	}
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(tunnelsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "create tunnels bucket")
+	}
+	return &boltStore{db: db, watchers: make(map[chan *v1.PeerEvent]struct{})}, nil
+}
+
+func (s *boltStore) CreateTunnel(ctx context.Context, t *v1.Tunnel) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tunnelsBucket)
+		if b.Get([]byte(t.ID)) != nil {
+			return store.ErrExists
+		}
+		return putTunnel(b, t)
+	})
+}
+
+func (s *boltStore) DeleteTunnel(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tunnelsBucket)
+		if b.Get([]byte(id)) == nil {
+			return store.ErrNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+func (s *boltStore) ListTunnels(ctx context.Context) ([]*v1.Tunnel, error) {
+	var tunnels []*v1.Tunnel
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(tunnelsBucket).ForEach(func(k, v []byte) error {
+			t := &v1.Tunnel{}
+			if err := json.Unmarshal(v, t); err != nil {
+				return errors.Wrap(err, "unmarshal tunnel")
+			}
+			tunnels = append(tunnels, t)
+			return nil
+		})
+	})
+	return tunnels, err
+}
+
+func (s *boltStore) PutPeer(ctx context.Context, tunnelID string, p *v1.Peer) error {
+	var event *v1.PeerEvent
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tunnelsBucket)
+		t, err := getTunnel(b, tunnelID)
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i, existing := range t.Peers {
+			if existing.ID == p.ID {
+				t.Peers[i] = p
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			t.Peers = append(t.Peers, p)
+		}
+		event = &v1.PeerEvent{Type: v1.PeerEvent_UPDATE, TunnelID: tunnelID, Peer: p}
+		return putTunnel(b, t)
+	})
+	if err != nil {
+		return err
+	}
+	s.notify(event)
+	return nil
+}
+
+func (s *boltStore) DeletePeer(ctx context.Context, tunnelID, peerID string) error {
+	var event *v1.PeerEvent
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tunnelsBucket)
+		t, err := getTunnel(b, tunnelID)
+		if err != nil {
+			return err
+		}
+		var (
+			removed *v1.Peer
+			peers   []*v1.Peer
+		)
+		for _, p := range t.Peers {
+			if p.ID == peerID {
+				removed = p
+				continue
+			}
+			peers = append(peers, p)
+		}
+		if removed == nil {
+			return store.ErrNotFound
+		}
+		t.Peers = peers
+		event = &v1.PeerEvent{Type: v1.PeerEvent_REMOVE, TunnelID: tunnelID, Peer: removed}
+		return putTunnel(b, t)
+	})
+	if err != nil {
+		return err
+	}
+	s.notify(event)
+	return nil
+}
+
+func (s *boltStore) Watch(ctx context.Context) (<-chan *v1.PeerEvent, error) {
+	ch := make(chan *v1.PeerEvent, 16)
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.watchers, ch)
+		s.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltStore) notify(e *v1.PeerEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.watchers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func getTunnel(b *bbolt.Bucket, id string) (*v1.Tunnel, error) {
+	data := b.Get([]byte(id))
+	if data == nil {
+		return nil, store.ErrNotFound
+	}
+	t := &v1.Tunnel{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, errors.Wrap(err, "unmarshal tunnel")
+	}
+	return t, nil
+}
+
+func putTunnel(b *bbolt.Bucket, t *v1.Tunnel) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "marshal tunnel")
+	}
+	return b.Put([]byte(t.ID), data)
+}
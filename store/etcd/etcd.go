@@ -0,0 +1,286 @@
+// Package etcd stores tunnels and peers as lease-bound keys in a shared
+// etcd cluster, selected with `--store etcd://host:2379/guard`. Because
+// etcd already has a native Watch, multiple guard servers pointed at the
+// same cluster converge without the cluster package's gossip protocol.
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"reflect"
+	"time"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/crosbymichael/guard/store"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// casRetries bounds how many times PutPeer/DeletePeer retry their
+// compare-and-swap on a concurrent writer before giving up.
+const casRetries = 10
+
+// leaseTTL is long enough to comfortably outlive the keepalive interval
+// etcd's client maintains in the background.
+const leaseTTL = 60 * time.Second
+
+type etcdStore struct {
+	client *clientv3.Client
+	prefix string
+	lease  clientv3.LeaseID
+}
+
+// New dials the etcd cluster at endpoints and stores tunnels/peers under
+// prefix.
+func New(endpoints []string, prefix string) (store.Store, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "dial etcd")
+	}
+	lease, err := client.Grant(context.Background(), int64(leaseTTL.Seconds()))
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrap(err, "grant etcd lease")
+	}
+	keepAlive, err := client.KeepAlive(context.Background(), lease.ID)
+	if err != nil {
+		client.Close()
+		return nil, errors.Wrap(err, "keepalive etcd lease")
+	}
+	go func() {
+		for range keepAlive {
+		}
+	}()
+	return &etcdStore{client: client, prefix: prefix, lease: lease.ID}, nil
+}
+
+func (s *etcdStore) key(id string) string {
+	return path.Join(s.prefix, "tunnels", id)
+}
+
+func (s *etcdStore) CreateTunnel(ctx context.Context, t *v1.Tunnel) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "marshal tunnel")
+	}
+	key := s.key(t.ID)
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(data), clientv3.WithLease(s.lease))).
+		Commit()
+	if err != nil {
+		return errors.Wrap(err, "put tunnel")
+	}
+	if !resp.Succeeded {
+		return store.ErrExists
+	}
+	return nil
+}
+
+func (s *etcdStore) DeleteTunnel(ctx context.Context, id string) error {
+	resp, err := s.client.Delete(ctx, s.key(id))
+	if err != nil {
+		return errors.Wrap(err, "delete tunnel")
+	}
+	if resp.Deleted == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+func (s *etcdStore) ListTunnels(ctx context.Context) ([]*v1.Tunnel, error) {
+	resp, err := s.client.Get(ctx, path.Join(s.prefix, "tunnels")+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.Wrap(err, "list tunnels")
+	}
+	tunnels := make([]*v1.Tunnel, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		t := &v1.Tunnel{}
+		if err := json.Unmarshal(kv.Value, t); err != nil {
+			return nil, errors.Wrap(err, "unmarshal tunnel")
+		}
+		tunnels = append(tunnels, t)
+	}
+	return tunnels, nil
+}
+
+// getTunnelRev returns the tunnel stored at id along with the ModRevision
+// its key was last written at, so a caller can compare-and-swap its
+// update back with putTunnelCAS instead of blindly overwriting a
+// concurrent writer.
+func (s *etcdStore) getTunnelRev(ctx context.Context, id string) (*v1.Tunnel, int64, error) {
+	resp, err := s.client.Get(ctx, s.key(id))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "get tunnel")
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, 0, store.ErrNotFound
+	}
+	t := &v1.Tunnel{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, t); err != nil {
+		return nil, 0, errors.Wrap(err, "unmarshal tunnel")
+	}
+	return t, resp.Kvs[0].ModRevision, nil
+}
+
+// putTunnelCAS writes t only if the key's ModRevision still matches
+// modRevision, reporting false (not an error) on a lost race so the
+// caller can re-read and retry.
+func (s *etcdStore) putTunnelCAS(ctx context.Context, t *v1.Tunnel, modRevision int64) (bool, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return false, errors.Wrap(err, "marshal tunnel")
+	}
+	key := s.key(t.ID)
+	resp, err := s.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", modRevision)).
+		Then(clientv3.OpPut(key, string(data), clientv3.WithLease(s.lease))).
+		Commit()
+	if err != nil {
+		return false, errors.Wrap(err, "put tunnel")
+	}
+	return resp.Succeeded, nil
+}
+
+// PutPeer retries its read-modify-write under compare-and-swap so two
+// guard servers sharing an etcd cluster can't race and silently clobber
+// each other's peer write.
+func (s *etcdStore) PutPeer(ctx context.Context, tunnelID string, p *v1.Peer) error {
+	for attempt := 0; attempt < casRetries; attempt++ {
+		t, rev, err := s.getTunnelRev(ctx, tunnelID)
+		if err != nil {
+			return err
+		}
+		replaced := false
+		for i, existing := range t.Peers {
+			if existing.ID == p.ID {
+				t.Peers[i] = p
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			t.Peers = append(t.Peers, p)
+		}
+		ok, err := s.putTunnelCAS(ctx, t, rev)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("put peer: too many concurrent writers, giving up")
+}
+
+// DeletePeer retries its read-modify-write under compare-and-swap for
+// the same reason PutPeer does.
+func (s *etcdStore) DeletePeer(ctx context.Context, tunnelID, peerID string) error {
+	for attempt := 0; attempt < casRetries; attempt++ {
+		t, rev, err := s.getTunnelRev(ctx, tunnelID)
+		if err != nil {
+			return err
+		}
+		var (
+			removed *v1.Peer
+			peers   []*v1.Peer
+		)
+		for _, p := range t.Peers {
+			if p.ID == peerID {
+				removed = p
+				continue
+			}
+			peers = append(peers, p)
+		}
+		if removed == nil {
+			return store.ErrNotFound
+		}
+		t.Peers = peers
+		ok, err := s.putTunnelCAS(ctx, t, rev)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errors.New("delete peer: too many concurrent writers, giving up")
+}
+
+// Watch relays etcd's native watch on the tunnels prefix as PeerEvents,
+// diffing the old and new tunnel value to figure out which peer changed.
+// This is what lets guard servers sharing an etcd cluster converge
+// without the cluster package's gossip stream.
+func (s *etcdStore) Watch(ctx context.Context) (<-chan *v1.PeerEvent, error) {
+	out := make(chan *v1.PeerEvent, 16)
+	watchCh := s.client.Watch(ctx, path.Join(s.prefix, "tunnels")+"/", clientv3.WithPrefix(), clientv3.WithPrevKV())
+	go func() {
+		defer close(out)
+		for resp := range watchCh {
+			for _, ev := range resp.Events {
+				for _, event := range diffEvents(ev) {
+					select {
+					case out <- event:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+func diffEvents(ev *clientv3.Event) []*v1.PeerEvent {
+	tunnelID := path.Base(string(ev.Kv.Key))
+
+	var before, after v1.Tunnel
+	if ev.PrevKv != nil {
+		if err := json.Unmarshal(ev.PrevKv.Value, &before); err != nil {
+			logrus.WithError(err).Warn("unmarshal previous tunnel value from etcd watch")
+		}
+	}
+	if ev.Type == clientv3.EventTypePut {
+		if err := json.Unmarshal(ev.Kv.Value, &after); err != nil {
+			logrus.WithError(err).Warn("unmarshal tunnel value from etcd watch")
+		}
+	}
+
+	beforeByID := map[string]*v1.Peer{}
+	for _, p := range before.Peers {
+		beforeByID[p.ID] = p
+	}
+	afterByID := map[string]*v1.Peer{}
+	for _, p := range after.Peers {
+		afterByID[p.ID] = p
+	}
+
+	var events []*v1.PeerEvent
+	for id, p := range afterByID {
+		prev, existed := beforeByID[id]
+		switch {
+		case !existed:
+			events = append(events, &v1.PeerEvent{Type: v1.PeerEvent_ADD, TunnelID: tunnelID, Peer: p})
+		case !reflect.DeepEqual(prev, p):
+			// Same peer ID, different contents: a rotated key or
+			// changed allowed ips, same as PutPeer replacing an
+			// existing peer on the file/bolt backends.
+			events = append(events, &v1.PeerEvent{Type: v1.PeerEvent_UPDATE, TunnelID: tunnelID, Peer: p})
+		}
+	}
+	for id, p := range beforeByID {
+		if _, ok := afterByID[id]; !ok {
+			events = append(events, &v1.PeerEvent{Type: v1.PeerEvent_REMOVE, TunnelID: tunnelID, Peer: p})
+		}
+	}
+	return events
+}
+
+func (s *etcdStore) Close() error {
+	return s.client.Close()
+}
@@ -0,0 +1,195 @@
+// Package file is the original directory-on-disk store backend: one json
+// file per tunnel, kept for `--store file://...` and as the default when
+// no store is configured.
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/crosbymichael/guard/store"
+	"github.com/pkg/errors"
+)
+
+type fileStore struct {
+	dir string
+
+	mu       sync.Mutex
+	watchers map[chan *v1.PeerEvent]struct{}
+
+	// writeMu serializes PutPeer/DeletePeer's read-modify-write of a
+	// tunnel file, so two concurrent callers (e.g. the CLI and cluster
+	// reconciliation) can't race and lose an update.
+	writeMu sync.Mutex
+}
+
+// New opens a directory store rooted at dir, creating it if necessary.
+func New(dir string) (store.Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "create store directory")
+	}
+	return &fileStore{dir: dir, watchers: make(map[chan *v1.PeerEvent]struct{})}, nil
+}
+
+func (s *fileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *fileStore) CreateTunnel(ctx context.Context, t *v1.Tunnel) error {
+	if _, err := os.Stat(s.path(t.ID)); err == nil {
+		return store.ErrExists
+	}
+	return s.write(t)
+}
+
+func (s *fileStore) DeleteTunnel(ctx context.Context, id string) error {
+	if err := os.Remove(s.path(id)); err != nil {
+		if os.IsNotExist(err) {
+			return store.ErrNotFound
+		}
+		return errors.Wrap(err, "remove tunnel file")
+	}
+	return nil
+}
+
+func (s *fileStore) ListTunnels(ctx context.Context) ([]*v1.Tunnel, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read store directory")
+	}
+	var tunnels []*v1.Tunnel
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, e.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "read tunnel file")
+		}
+		t := &v1.Tunnel{}
+		if err := json.Unmarshal(data, t); err != nil {
+			return nil, errors.Wrap(err, "unmarshal tunnel file")
+		}
+		tunnels = append(tunnels, t)
+	}
+	return tunnels, nil
+}
+
+func (s *fileStore) PutPeer(ctx context.Context, tunnelID string, p *v1.Peer) error {
+	s.writeMu.Lock()
+	t, err := s.get(tunnelID)
+	if err != nil {
+		s.writeMu.Unlock()
+		return err
+	}
+	replaced := false
+	for i, existing := range t.Peers {
+		if existing.ID == p.ID {
+			t.Peers[i] = p
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		t.Peers = append(t.Peers, p)
+	}
+	err = s.write(t)
+	s.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.notify(&v1.PeerEvent{Type: v1.PeerEvent_UPDATE, TunnelID: tunnelID, Peer: p})
+	return nil
+}
+
+func (s *fileStore) DeletePeer(ctx context.Context, tunnelID, peerID string) error {
+	s.writeMu.Lock()
+	t, err := s.get(tunnelID)
+	if err != nil {
+		s.writeMu.Unlock()
+		return err
+	}
+	var (
+		removed *v1.Peer
+		peers   []*v1.Peer
+	)
+	for _, p := range t.Peers {
+		if p.ID == peerID {
+			removed = p
+			continue
+		}
+		peers = append(peers, p)
+	}
+	if removed == nil {
+		s.writeMu.Unlock()
+		return store.ErrNotFound
+	}
+	t.Peers = peers
+	err = s.write(t)
+	s.writeMu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.notify(&v1.PeerEvent{Type: v1.PeerEvent_REMOVE, TunnelID: tunnelID, Peer: removed})
+	return nil
+}
+
+func (s *fileStore) Watch(ctx context.Context) (<-chan *v1.PeerEvent, error) {
+	ch := make(chan *v1.PeerEvent, 16)
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.watchers, ch)
+		s.mu.Unlock()
+	}()
+	return ch, nil
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
+
+func (s *fileStore) get(id string) (*v1.Tunnel, error) {
+	data, err := ioutil.ReadFile(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, store.ErrNotFound
+		}
+		return nil, errors.Wrap(err, "read tunnel file")
+	}
+	t := &v1.Tunnel{}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, errors.Wrap(err, "unmarshal tunnel file")
+	}
+	return t, nil
+}
+
+func (s *fileStore) write(t *v1.Tunnel) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.Wrap(err, "marshal tunnel")
+	}
+	if err := ioutil.WriteFile(s.path(t.ID), data, 0600); err != nil {
+		return errors.Wrap(err, "write tunnel file")
+	}
+	return nil
+}
+
+func (s *fileStore) notify(e *v1.PeerEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.watchers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/crosbymichael/guard/store/bolt"
+	"github.com/crosbymichael/guard/store/etcd"
+	"github.com/crosbymichael/guard/store/file"
+	"github.com/pkg/errors"
+)
+
+// New parses rawurl and opens the matching backend:
+//
+//	file:///var/lib/guard          a directory of per-tunnel json files
+//	bolt:///var/lib/guard/guard.db  a single embedded bbolt database
+//	etcd://host1:2379,host2:2379/guard  a shared etcd cluster
+func New(rawurl string) (Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse store url")
+	}
+	switch u.Scheme {
+	case "file", "":
+		return file.New(u.Path)
+	case "bolt":
+		return bolt.New(u.Path)
+	case "etcd":
+		prefix := u.Path
+		return etcd.New(splitHosts(u.Host), prefix)
+	default:
+		return nil, errors.Errorf("unsupported store scheme %q", u.Scheme)
+	}
+}
+
+func splitHosts(host string) []string {
+	if host == "" {
+		return nil
+	}
+	return strings.Split(host, ",")
+}
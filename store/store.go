@@ -0,0 +1,40 @@
+// Package store defines the persistence boundary for guard's tunnel and
+// peer state, so a server isn't limited to a directory on its own disk.
+// Concrete backends live in the file, bolt, and etcd subpackages and are
+// selected at runtime by the scheme of a --store URL.
+package store
+
+import (
+	"context"
+
+	v1 "github.com/crosbymichael/guard/api/v1"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by DeleteTunnel/PutPeer/DeletePeer when the
+// tunnel or peer they target does not exist in the store.
+var ErrNotFound = errors.New("not found in store")
+
+// ErrExists is returned by CreateTunnel when a tunnel with the same ID
+// is already present in the store.
+var ErrExists = errors.New("already exists in store")
+
+// Store persists tunnels and their peers and notifies watchers of peer
+// writes, so multiple guard servers pointed at the same store converge
+// without a custom gossip protocol.
+type Store interface {
+	CreateTunnel(ctx context.Context, t *v1.Tunnel) error
+	DeleteTunnel(ctx context.Context, id string) error
+	ListTunnels(ctx context.Context) ([]*v1.Tunnel, error)
+
+	PutPeer(ctx context.Context, tunnelID string, p *v1.Peer) error
+	DeletePeer(ctx context.Context, tunnelID, peerID string) error
+
+	// Watch streams a PeerEvent for every PutPeer/DeletePeer until ctx
+	// is canceled. The etcd backend also reports writes made by other
+	// processes sharing the store; the file and bolt backends report
+	// only writes made through the same Store value.
+	Watch(ctx context.Context) (<-chan *v1.PeerEvent, error)
+
+	Close() error
+}
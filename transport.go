@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/crosbymichael/guard/client"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc"
+)
+
+// tlsFlags are shared between the server and every client subcommand so
+// `--tls-cert`, `--tls-key`, and `--tls-ca` behave the same everywhere.
+var tlsFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:   "tls-cert",
+		Usage:  "tls certificate for mutual tls",
+		EnvVar: "GUARD_TLS_CERT",
+	},
+	cli.StringFlag{
+		Name:   "tls-key",
+		Usage:  "tls key for mutual tls",
+		EnvVar: "GUARD_TLS_KEY",
+	},
+	cli.StringFlag{
+		Name:   "tls-ca",
+		Usage:  "ca certificate used to verify the peer",
+		EnvVar: "GUARD_TLS_CA",
+	},
+}
+
+// serverTLSConfig builds a *tls.Config for the grpc server from the
+// --tls-cert/--tls-key/--tls-ca flags. It returns nil, nil when none of
+// the flags are set so the caller can fall back to an insecure listener.
+func serverTLSConfig(clix *cli.Context) (*tls.Config, error) {
+	cert, key, ca := clix.String("tls-cert"), clix.String("tls-key"), clix.String("tls-ca")
+	if cert == "" && key == "" && ca == "" {
+		return nil, nil
+	}
+	if cert == "" || key == "" {
+		return nil, errors.New("tls-cert and tls-key must both be set")
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "load tls keypair")
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{pair},
+	}
+	if ca != "" {
+		data, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, errors.Wrap(err, "read ca certificate")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificates found in %s", ca)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// clientConfig builds a client.Config from the tls/token flags common to
+// every CLI subcommand, all of which are declared globally on the app.
+func clientConfig(clix *cli.Context) client.Config {
+	return client.Config{
+		Address:   clix.GlobalString("address"),
+		TLSCert:   clix.GlobalString("tls-cert"),
+		TLSKey:    clix.GlobalString("tls-key"),
+		TLSCA:     clix.GlobalString("tls-ca"),
+		Token:     clix.GlobalString("token"),
+		TokenFile: clix.GlobalString("token-file"),
+	}
+}
+
+// dialOptions resolves the dial options every CLI subcommand uses to
+// talk to the guard server.
+func dialOptions(clix *cli.Context) ([]grpc.DialOption, error) {
+	return clientConfig(clix).DialOptions()
+}
+
+// dial opens a connection to the guard server using the tls/token flags
+// common to every CLI subcommand.
+func dial(clix *cli.Context) (*grpc.ClientConn, error) {
+	return clientConfig(clix).Dial()
+}